@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+var decryptCommand = &cli.Command{
+	Name:   "decrypt",
+	Usage:  "Fetch an encrypted backup object from MinIO and decrypt it locally",
+	Action: Decrypt,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "endpoint",
+			Usage:    "MinIO endpoint",
+			Required: true,
+			EnvVars:  []string{"PB_ENDPOINT"},
+		},
+		&cli.StringFlag{
+			Name:     "access-key",
+			Usage:    "MinIO access key",
+			Required: true,
+			EnvVars:  []string{"PB_ACCESS_KEY"},
+		},
+		&cli.StringFlag{
+			Name:     "secret-key",
+			Usage:    "MinIO secret key",
+			Required: true,
+			EnvVars:  []string{"PB_SECRET_KEY"},
+		},
+		&cli.StringFlag{
+			Name:     "bucket",
+			Usage:    "MinIO bucket",
+			Required: true,
+			EnvVars:  []string{"PB_BUCKET"},
+		},
+		&cli.BoolFlag{
+			Name:  "use-ssl",
+			Usage: "Enable SSL for MinIO endpoint",
+			Value: true,
+		},
+		&cli.StringFlag{
+			Name:     "object",
+			Usage:    "Key of the encrypted object to fetch, e.g. myapp/2023-01-02T15:04:05Z.sql.gpg",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "output",
+			Usage:    "Path to write the decrypted dump to",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:    "encrypt-passphrase",
+			Usage:   "Passphrase the object was symmetrically encrypted with",
+			EnvVars: []string{"PB_ENCRYPT_PASSPHRASE"},
+		},
+		&cli.StringFlag{
+			Name:    "decrypt-privkey-file",
+			Usage:   "Path to the armored OpenPGP private key matching the public key used to encrypt the object",
+			EnvVars: []string{"PB_DECRYPT_PRIVKEY_FILE"},
+		},
+	},
+}
+
+// Decrypt fetches an encrypted backup object from MinIO and writes its
+// decrypted contents to the path given by --output.
+func Decrypt(ctx *cli.Context) error {
+	minioClient, err := minio.New(ctx.String("endpoint"), &minio.Options{
+		Creds:  credentials.NewStaticV4(ctx.String("access-key"), ctx.String("secret-key"), ""),
+		Secure: ctx.Bool("use-ssl"),
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to create MinIO client")
+	}
+
+	object, err := minioClient.GetObject(context.Background(), ctx.String("bucket"), ctx.String("object"), minio.GetObjectOptions{})
+	if err != nil {
+		return errors.Wrap(err, "unable to fetch object")
+	}
+	defer object.Close()
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, object); err != nil {
+		return errors.Wrap(err, "unable to read object")
+	}
+
+	decrypted, err := decryptDump(buf.Bytes(), &EncryptOptions{passphrase: ctx.String("encrypt-passphrase")}, ctx.String("decrypt-privkey-file"))
+	if err != nil {
+		return errors.Wrap(err, "unable to decrypt object")
+	}
+
+	return os.WriteFile(ctx.String("output"), decrypted, 0o600)
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/pkg/errors"
+)
+
+// DumpData runs pg_dump inside container according to its effective format
+// (plain, custom or directory, see dumpopts.go) and streams the result back
+// to the caller along with the file extension it should be uploaded under.
+// Plain and custom dumps are streamed straight off pg_dump's stdout; the
+// directory format has to be written inside the container first and is then
+// streamed out as a tar archive.
+func (b *Backuper) DumpData(container types.Container, dbName, user string) (io.ReadCloser, string, error) {
+	format := b.dumpFormatFor(container)
+	jobs := b.dumpJobsFor(container)
+
+	if format == "directory" {
+		return b.dumpDirectoryFormat(container, dbName, user, jobs)
+	}
+
+	argv := buildPgDumpCmd(container, dbName, user, format, jobs, "")
+	reader, err := b.execStreamStdout(container, argv)
+	if err != nil {
+		return nil, "", err
+	}
+	return reader, extensionForFormat(format), nil
+}
+
+// dumpDirectoryFormat runs pg_dump -Fd into a scratch directory inside the
+// container, then streams that directory out as a tar archive via
+// CopyFromContainer, which builds the tar on the fly rather than buffering
+// it. The scratch directory is removed once the stream is closed.
+func (b *Backuper) dumpDirectoryFormat(container types.Container, dbName, user string, jobs int) (io.ReadCloser, string, error) {
+	tmpDir := fmt.Sprintf("/tmp/pg-backup-%s", container.ID[:12])
+	argv := buildPgDumpCmd(container, dbName, user, "directory", jobs, tmpDir)
+	if err := b.execArgv(container.ID, argv); err != nil {
+		return nil, "", errors.Wrap(err, "pg_dump -Fd failed")
+	}
+
+	reader, _, err := b.cli.CopyFromContainer(b.ctx, container.ID, tmpDir)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "unable to copy dump directory out of container")
+	}
+
+	return &cleanupReader{ReadCloser: reader, cleanup: func() {
+		if err := b.execArgv(container.ID, []string{"rm", "-rf", tmpDir}); err != nil {
+			b.log.Println("Failed to clean up", tmpDir, "in", container.ID[:12], ":", err)
+		}
+	}}, "tar", nil
+}
+
+// cleanupReader runs cleanup once the wrapped ReadCloser is closed.
+type cleanupReader struct {
+	io.ReadCloser
+	cleanup func()
+}
+
+func (c *cleanupReader) Close() error {
+	err := c.ReadCloser.Close()
+	c.cleanup()
+	return err
+}
+
+// execStreamStdout runs argv inside container directly (no shell) and
+// streams its demultiplexed stdout back to the caller; stderr is drained and
+// logged as it arrives.
+func (b *Backuper) execStreamStdout(container types.Container, argv []string) (io.ReadCloser, error) {
+	execResp, err := b.cli.ContainerExecCreate(b.ctx, container.ID, types.ExecConfig{
+		Cmd:          argv,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create exec")
+	}
+
+	attachResp, err := b.cli.ContainerExecAttach(b.ctx, execResp.ID, types.ExecStartCheck{})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to attach to exec")
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer attachResp.Close()
+		_, copyErr := stdcopy.StdCopy(pw, &stderrLogger{b: b, containerID: container.ID[:12]}, attachResp.Reader)
+
+		b.waitForExecToEnd(execResp.ID)
+		inspect, inspectErr := b.cli.ContainerExecInspect(b.ctx, execResp.ID)
+		switch {
+		case copyErr != nil:
+			pw.CloseWithError(copyErr)
+		case inspectErr != nil:
+			pw.CloseWithError(errors.Wrap(inspectErr, "unable to inspect exec"))
+		case inspect.ExitCode != 0:
+			pw.CloseWithError(fmt.Errorf("command exited with code %d", inspect.ExitCode))
+		default:
+			pw.Close()
+		}
+	}()
+
+	return pr, nil
+}
+
+// stderrLogger is an io.Writer that logs pg_dump's stderr output, one log
+// entry per chunk, as it is demultiplexed from the exec stream.
+type stderrLogger struct {
+	b           *Backuper
+	containerID string
+}
+
+func (l *stderrLogger) Write(p []byte) (int, error) {
+	l.b.log.Println("pg_dump stderr for", l.containerID, ":", string(p))
+	return len(p), nil
+}
+
+// progressReader wraps a reader and periodically logs how many bytes have
+// been uploaded, so long-running uploads give visible feedback.
+type progressReader struct {
+	reader     io.Reader
+	b          *Backuper
+	appName    string
+	total      int64
+	lastLogged int64
+}
+
+const progressLogThreshold = 10 * 1024 * 1024 // log every 10MB uploaded
+
+func (p *progressReader) Read(buf []byte) (int, error) {
+	n, err := p.reader.Read(buf)
+	p.total += int64(n)
+	if p.total-p.lastLogged >= progressLogThreshold {
+		p.b.log.Println("Uploaded", p.total, "bytes so far for", p.appName)
+		p.lastLogged = p.total
+	}
+	return n, err
+}
+
+// fanoutChunks is how many 32KB chunks each backend's relay goroutine may
+// buffer before UploadDump gives up on it; see fanOutTo.
+const fanoutChunks = 4
+
+// UploadDump streams reader (optionally through encryption) straight into
+// every configured Storage backend under "<appName>/<now>.<ext>" (plus a
+// ".gpg" suffix when encryption is enabled), returning the number of bytes
+// read from the source. With a single backend the stream goes straight into
+// its Put; with several, each backend gets its own buffered relay goroutine,
+// so a slow or unreachable one falls behind and is dropped from this upload
+// instead of blocking the others.
+func (b *Backuper) UploadDump(appName string, reader io.ReadCloser, ext, now string) (int64, error) {
+	defer reader.Close()
+
+	var source io.Reader = reader
+	if b.options.encrypt != nil {
+		encrypted, err := encryptStream(reader, b.options.encrypt)
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to encrypt backup file")
+		}
+		source = encrypted
+		ext += ".gpg"
+	}
+
+	progress := &progressReader{reader: source, b: b, appName: appName}
+	key := fmt.Sprintf("%s/%s.%s", appName, now, ext)
+
+	if len(b.storages) == 1 {
+		if err := b.storages[0].Put(b.ctx, key, progress, -1); err != nil {
+			return 0, errors.Wrap(err, "failed to upload backup file")
+		}
+		b.log.Println("Uploaded backup file", key)
+		return progress.total, nil
+	}
+
+	aggregated := b.fanOutTo(key, progress)
+	if aggregated != nil {
+		return 0, aggregated
+	}
+	b.log.Println("Uploaded backup file", key, "to", len(b.storages), "storage backends")
+	return progress.total, nil
+}
+
+// fanOutTo reads source once and relays it to every configured Storage
+// backend independently: each backend has its own buffered channel and
+// relay goroutine feeding its upload pipe, so a backend that can't keep up
+// only fills its own buffer rather than blocking the shared read loop the
+// way writing through a single io.MultiWriter would. Once a backend's
+// buffer is full, it's dropped from this upload and its Put fails instead
+// of the whole upload hanging.
+func (b *Backuper) fanOutTo(key string, source io.Reader) error {
+	type target struct {
+		chunks  chan []byte
+		pw      *io.PipeWriter
+		dropped bool
+	}
+
+	targets := make([]*target, len(b.storages))
+	results := make(chan error, len(b.storages))
+	for i, storage := range b.storages {
+		pr, pw := io.Pipe()
+		t := &target{chunks: make(chan []byte, fanoutChunks), pw: pw}
+		targets[i] = t
+
+		go func(storage Storage, pr *io.PipeReader) {
+			results <- errors.Wrap(storage.Put(b.ctx, key, pr, -1), "upload to storage backend failed")
+		}(storage, pr)
+
+		go func(t *target) {
+			for chunk := range t.chunks {
+				if _, err := t.pw.Write(chunk); err != nil {
+					break
+				}
+			}
+			t.pw.Close()
+		}(t)
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := source.Read(buf)
+		if n > 0 {
+			chunk := append([]byte(nil), buf[:n]...)
+			for _, t := range targets {
+				if t.dropped {
+					continue
+				}
+				select {
+				case t.chunks <- chunk:
+				default:
+					b.log.Println("Storage backend fell behind during upload, dropping it from this backup")
+					t.pw.CloseWithError(errors.New("storage backend fell behind during upload"))
+					t.dropped = true
+					close(t.chunks)
+				}
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				for _, t := range targets {
+					if !t.dropped {
+						t.pw.CloseWithError(err)
+						t.dropped = true
+						close(t.chunks)
+					}
+				}
+			}
+			break
+		}
+	}
+	for _, t := range targets {
+		if !t.dropped {
+			close(t.chunks)
+		}
+	}
+
+	var aggregated error
+	for range targets {
+		if err := <-results; err != nil {
+			aggregated = multierror(aggregated, err)
+		}
+	}
+	return aggregated
+}
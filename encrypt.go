@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp"
+)
+
+// EncryptOptions configures client-side encryption of dumps before they are
+// uploaded. Exactly one of passphrase (symmetric) or pubKeyFile (public-key)
+// is expected to be set.
+type EncryptOptions struct {
+	passphrase string
+	pubKeyFile string
+}
+
+// encryptStream wraps r so that reading from the result yields the OpenPGP
+// ciphertext of r's contents, ready to be uploaded as "*.gpg". Encryption
+// happens incrementally in a background goroutine so the dump never has to
+// be buffered whole in memory.
+func encryptStream(r io.Reader, opts *EncryptOptions) (io.Reader, error) {
+	pr, pw := io.Pipe()
+
+	go func() {
+		var w io.WriteCloser
+		var err error
+		if opts.pubKeyFile != "" {
+			var entity *openpgp.Entity
+			entity, err = readPublicKey(opts.pubKeyFile)
+			if err == nil {
+				w, err = openpgp.Encrypt(pw, []*openpgp.Entity{entity}, nil, nil, nil)
+			}
+		} else {
+			w, err = openpgp.SymmetricallyEncrypt(pw, []byte(opts.passphrase), nil, nil)
+		}
+		if err != nil {
+			pw.CloseWithError(errors.Wrap(err, "unable to start encryption"))
+			return
+		}
+
+		_, copyErr := io.Copy(w, r)
+		closeErr := w.Close()
+		if copyErr != nil {
+			pw.CloseWithError(errors.Wrap(copyErr, "unable to encrypt dump"))
+			return
+		}
+		pw.CloseWithError(closeErr)
+	}()
+
+	return pr, nil
+}
+
+// decryptDump reverses encryptStream, using opts.passphrase for symmetric
+// ciphertext or privKeyFile for public-key ciphertext.
+func decryptDump(data []byte, opts *EncryptOptions, privKeyFile string) ([]byte, error) {
+	var keyring openpgp.EntityList
+	if privKeyFile != "" {
+		entity, err := readPrivateKey(privKeyFile, opts.passphrase)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read private key")
+		}
+		keyring = openpgp.EntityList{entity}
+	}
+
+	promptedPassphrase := []byte(opts.passphrase)
+	md, err := openpgp.ReadMessage(bytes.NewReader(data), keyring, func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		return promptedPassphrase, nil
+	}, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to decrypt dump")
+	}
+	return io.ReadAll(md.UnverifiedBody)
+}
+
+func readPublicKey(path string) (*openpgp.Entity, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entities, err := openpgp.ReadArmoredKeyRing(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(entities) == 0 {
+		return nil, errors.New("no key found in " + path)
+	}
+	return entities[0], nil
+}
+
+func readPrivateKey(path, passphrase string) (*openpgp.Entity, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entities, err := openpgp.ReadArmoredKeyRing(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(entities) == 0 {
+		return nil, errors.New("no key found in " + path)
+	}
+	entity := entities[0]
+	if entity.PrivateKey != nil && entity.PrivateKey.Encrypted {
+		if err := entity.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+			return nil, errors.Wrap(err, "unable to decrypt private key")
+		}
+	}
+	for _, subkey := range entity.Subkeys {
+		if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+			_ = subkey.PrivateKey.Decrypt([]byte(passphrase))
+		}
+	}
+	return entity, nil
+}
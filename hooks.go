@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/pkg/errors"
+)
+
+const (
+	preExecLabel  = "postgres-backup/pre-exec"
+	postExecLabel = "postgres-backup/post-exec"
+	groupLabel    = "postgres-backup/group"
+)
+
+// execInContainer runs cmd inside container via a shell, waits for it to
+// finish and returns an error if it could not be started or exited non-zero.
+// Only use this for trusted, operator-authored commands (e.g. lifecycle
+// hooks); anything built from label values an attacker could influence
+// should go through execArgv instead.
+func (b *Backuper) execInContainer(containerID, cmd string) error {
+	return b.execArgv(containerID, []string{"bash", "-c", cmd})
+}
+
+// execArgv runs argv directly inside container, with no shell involved, and
+// waits for it to finish, returning an error if it could not be started or
+// exited non-zero. Unlike execInContainer, argv elements are never
+// interpreted by a shell, so this is safe to use with untrusted values.
+func (b *Backuper) execArgv(containerID string, argv []string) error {
+	execResp, err := b.cli.ContainerExecCreate(b.ctx, containerID, types.ExecConfig{
+		Cmd:    argv,
+		Detach: false,
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to create exec")
+	}
+	if err := b.cli.ContainerExecStart(b.ctx, execResp.ID, types.ExecStartCheck{}); err != nil {
+		return errors.Wrap(err, "unable to start exec")
+	}
+	b.waitForExecToEnd(execResp.ID)
+
+	inspect, err := b.cli.ContainerExecInspect(b.ctx, execResp.ID)
+	if err != nil {
+		return errors.Wrap(err, "unable to inspect exec")
+	}
+	if inspect.ExitCode != 0 {
+		return fmt.Errorf("command exited with code %d", inspect.ExitCode)
+	}
+	return nil
+}
+
+// groupMembers returns container, and any other container sharing its
+// postgres-backup/group label, so hooks can be run across a whole stack.
+func (b *Backuper) groupMembers(container types.Container) []types.Container {
+	group, ok := container.Labels[groupLabel]
+	if !ok {
+		return []types.Container{container}
+	}
+	siblings, err := b.cli.ContainerList(b.ctx, types.ContainerListOptions{
+		Filters: filters.NewArgs(filters.KeyValuePair{Key: "label", Value: fmt.Sprintf("%s=%s", groupLabel, group)}),
+	})
+	if err != nil {
+		b.log.Println("Failed to list group", group, "falling back to", container.ID[:12], ":", err)
+		return []types.Container{container}
+	}
+	return siblings
+}
+
+// runLifecycleHooks runs the command found in labelKey on container and
+// every member of its postgres-backup/group, aggregating any errors.
+func (b *Backuper) runLifecycleHooks(container types.Container, labelKey string) error {
+	var aggregated error
+	for _, member := range b.groupMembers(container) {
+		cmd, ok := member.Labels[labelKey]
+		if !ok || cmd == "" {
+			continue
+		}
+		if err := b.execInContainer(member.ID, cmd); err != nil {
+			aggregated = multierror(aggregated, errors.Wrapf(err, "hook %s on %s", labelKey, getAppName(member)))
+		}
+	}
+	return aggregated
+}
+
+// multierror combines two errors, keeping whichever ones are non-nil.
+func multierror(existing, next error) error {
+	if existing == nil {
+		return next
+	}
+	if next == nil {
+		return existing
+	}
+	return fmt.Errorf("%s; %s", existing, next)
+}
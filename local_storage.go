@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// localStorage writes backups to a directory on the local filesystem, for
+// air-gapped or on-host backups.
+type localStorage struct {
+	baseDir string
+}
+
+func newLocalStorage(baseDir string) *localStorage {
+	return &localStorage{baseDir: baseDir}
+}
+
+func (l *localStorage) resolve(key string) (string, error) {
+	return resolveUnderDir(l.baseDir, key)
+}
+
+func (l *localStorage) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	path, err := l.resolve(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return errors.Wrap(err, "unable to create backup directory")
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "unable to create backup file")
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return errors.Wrap(err, "unable to write backup file")
+	}
+	return nil
+}
+
+func (l *localStorage) List(ctx context.Context, prefix string) ([]StorageObject, error) {
+	root, err := l.resolve(prefix)
+	if err != nil {
+		return nil, err
+	}
+	var objects []StorageObject
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(l.baseDir, path)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, StorageObject{
+			Key:          filepath.ToSlash(rel),
+			LastModified: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "unable to list backup directory")
+	}
+	return objects, nil
+}
+
+func (l *localStorage) Delete(ctx context.Context, keys []string) error {
+	var aggregated error
+	for _, key := range keys {
+		path, err := l.resolve(key)
+		if err != nil {
+			aggregated = multierror(aggregated, err)
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			aggregated = multierror(aggregated, err)
+		}
+	}
+	return aggregated
+}
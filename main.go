@@ -1,8 +1,6 @@
 package main
 
 import (
-	"archive/tar"
-	"bytes"
 	"context"
 	"fmt"
 	"github.com/docker/docker/api/types"
@@ -13,7 +11,6 @@ import (
 	"github.com/pkg/errors"
 	"github.com/robfig/cron/v3"
 	"github.com/urfave/cli/v2"
-	"io"
 	"log"
 	"os"
 	"os/signal"
@@ -21,38 +18,6 @@ import (
 	"time"
 )
 
-// https://github.com/cortexlabs/cortex/blob/dc5f73277d421c947129dc69a53597f196873f5e/pkg/lib/archive/tar.go#L79
-func UntarReaderToMem(reader io.Reader) (map[string][]byte, error) {
-	fileMap := map[string][]byte{}
-
-	tarReader := tar.NewReader(reader)
-
-	for {
-		header, err := tarReader.Next()
-
-		switch {
-		case err == io.EOF:
-			return fileMap, nil
-
-		case err != nil:
-			return nil, err
-
-		case header == nil:
-			continue
-		}
-
-		if header.Typeflag == tar.TypeReg {
-			contents, err := io.ReadAll(tarReader)
-			if err != nil {
-				return nil, errors.Wrap(err, "unable to extract tar file")
-			}
-
-			path := strings.TrimPrefix(header.Name, "/")
-			fileMap[path] = contents
-		}
-	}
-}
-
 func getAppName(container types.Container) (appName string) {
 	if value, ok := container.Labels["postgres-backup/app-name"]; ok {
 		return value
@@ -141,17 +106,22 @@ func registerExitHandler(done chan bool) {
 }
 
 type Backuper struct {
-	cli     *client.Client
-	ctx     context.Context
-	log     *log.Logger
-	cron    *cron.Cron
-	minio   *minio.Client
-	options *BackuperOptions
+	cli      *client.Client
+	ctx      context.Context
+	log      *log.Logger
+	cron     *cron.Cron
+	storages []Storage
+	options  *BackuperOptions
 }
 
 type BackuperOptions struct {
-	minio    *MinioBackuperOptions
-	schedule string
+	minio       *MinioBackuperOptions
+	schedule    string
+	retention   *RetentionOptions
+	encrypt     *EncryptOptions
+	notify      *NotifyOptions
+	storageURLs []string
+	dump        *DumpOptions
 }
 
 type MinioBackuperOptions struct {
@@ -160,19 +130,38 @@ type MinioBackuperOptions struct {
 	minioOptions *minio.Options
 }
 
+// NewBackuper wires up the Docker client and every configured Storage
+// backend: the default MinIO destination, if configured, plus one per
+// --storage-url.
 func NewBackuper(options *BackuperOptions) Backuper {
 	dockerCli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
 		panic(fmt.Sprintln("Could not create Docker client:", err))
 	}
-	minioClient, err := minio.New(options.minio.endpoint, options.minio.minioOptions)
+
+	var storages []Storage
+	if options.minio != nil {
+		defaultStorage, err := newMinioStorage(options.minio)
+		if err != nil {
+			panic(fmt.Sprintln("Could not create MinIO client:", err))
+		}
+		storages = append(storages, defaultStorage)
+	}
+	for _, rawURL := range options.storageURLs {
+		storage, err := newStorage(rawURL)
+		if err != nil {
+			panic(fmt.Sprintln("Could not create storage backend for", rawURL, ":", err))
+		}
+		storages = append(storages, storage)
+	}
+
 	return Backuper{
-		ctx:     context.Background(),
-		cli:     dockerCli,
-		log:     log.Default(),
-		cron:    cron.New(),
-		minio:   minioClient,
-		options: options,
+		ctx:      context.Background(),
+		cli:      dockerCli,
+		log:      log.Default(),
+		cron:     cron.New(),
+		storages: storages,
+		options:  options,
 	}
 }
 
@@ -200,74 +189,132 @@ func (b *Backuper) Scan() {
 	if err != nil {
 		fmt.Println("Failed to list containers:", err)
 	}
+	result = append(result, b.scanSwarmServices()...)
+
+	stats := NotifyStats{}
 	for _, container := range result {
-		b.BackupContainer(container)
+		if err := b.BackupContainer(container); err != nil {
+			stats.Failed++
+		} else {
+			stats.Successful++
+		}
+		b.PruneOldBackups(getAppName(container))
 	}
+	b.notify(NotifyEvent{Stats: stats, Error: runStatsError(stats)})
 	b.log.Println("End containers scan")
 }
 
-func (b *Backuper) DumpData(container types.Container, dbName, user string) (io.ReadCloser, error) {
-	execResp, err := b.cli.ContainerExecCreate(b.ctx, container.ID, types.ExecConfig{
-		Cmd:    []string{"bash", "-c", fmt.Sprintf("pg_dump -U %s %s > /tmp/dump.sql", user, dbName)},
-		Detach: false,
-	})
-	if err != nil {
-		return nil, err
-	}
+// globalsTimestampSuffix is appended to the shared "now" timestamp when
+// uploading the pg_dumpall globals companion to a dump, so the two objects
+// never land in the same GFS day/week/month bucket in prune.go despite
+// sharing the same start time; see parseBackupObject.
+const globalsTimestampSuffix = "-globals"
 
-	err = b.cli.ContainerExecStart(b.ctx, execResp.ID, types.ExecStartCheck{})
-	if err != nil {
-		return nil, err
+func (b *Backuper) BackupContainer(container types.Container) error {
+	b.log.Println("Starting backup for container", container.ID[:12])
+	appName := getAppName(container)
+	start := time.Now()
+
+	defer func() {
+		if err := b.runLifecycleHooks(container, postExecLabel); err != nil {
+			b.log.Println("Post-exec hooks failed for", appName, ":", err)
+		}
+	}()
+
+	notifyResult := func(size int64, err error) error {
+		b.notify(NotifyEvent{
+			AppName:   appName,
+			Container: container.ID[:12],
+			Error:     err,
+			Size:      size,
+			Duration:  time.Since(start),
+		})
+		return err
 	}
-	b.waitForExecToEnd(execResp.ID)
 
-	reader, _, err := b.cli.CopyFromContainer(b.ctx, container.ID, "/tmp/dump.sql")
-	if err != nil {
-		return nil, err
+	if err := b.runLifecycleHooks(container, preExecLabel); err != nil {
+		log.Println("Pre-exec hooks failed for", appName, ":", err)
+		return notifyResult(0, err)
 	}
-	return reader, nil
-}
 
-func (b *Backuper) UploadDump(appName string, reader io.ReadCloser) {
-	defer reader.Close()
-	mem, err := UntarReaderToMem(reader)
-	now := time.Now().Format(time.RFC3339)
-	info, err := b.minio.PutObject(b.ctx, b.options.minio.bucket, fmt.Sprintf("%s/%s.sql", appName, now), bytes.NewReader(mem["dump.sql"]), -1, minio.PutObjectOptions{})
+	restore, err := b.quiesceGroup(container)
 	if err != nil {
-		b.log.Println("Failed to upload backup file for", appName, ":", err)
+		log.Println("Failed to quiesce group for", appName, ":", err)
+		return notifyResult(0, err)
 	}
-	b.log.Println("Uploaded backup file", info.Location)
-}
+	defer restore()
 
-func (b *Backuper) BackupContainer(container types.Container) {
-	b.log.Println("Starting backup for container", container.ID[:12])
-	appName := getAppName(container)
 	dbName := b.getDatabaseName(container)
 	user := b.getDatabaseUser(container)
-	response, err := b.DumpData(container, dbName, user)
+	now := start.Format(time.RFC3339)
+
+	response, ext, err := b.DumpData(container, dbName, user)
 	if err != nil {
 		log.Println("Failed to dump data for", container.ID[:12], ":", err)
-		return
+		return notifyResult(0, err)
+	}
+	size, err := b.UploadDump(appName, response, ext, now)
+	if err != nil {
+		b.log.Println("Failed to upload backup for", container.ID[:12], ":", err)
+		return notifyResult(0, err)
 	}
-	b.UploadDump(appName, response)
+
+	globals, err := b.execStreamStdout(container, []string{"pg_dumpall", "-U", user, "--globals-only"})
+	if err != nil {
+		b.log.Println("Failed to dump globals for", appName, ":", err)
+	} else if _, err := b.UploadDump(appName, globals, "globals.sql", now+globalsTimestampSuffix); err != nil {
+		b.log.Println("Failed to upload globals for", appName, ":", err)
+	}
+
 	b.log.Println("Finished backup for container", container.ID[:12])
+	return notifyResult(size, nil)
 }
 
 func Do(ctx *cli.Context) error {
 	done := make(chan bool)
 	registerExitHandler(done)
 
-	minioOptions := &minio.Options{
-		Creds:  credentials.NewStaticV4(ctx.String("access-key"), ctx.String("secret-key"), ""),
-		Secure: ctx.Bool("use-ssl"),
-	}
 	options := &BackuperOptions{
 		schedule: ctx.String("schedule"),
-		minio: &MinioBackuperOptions{
-			endpoint:     ctx.String("endpoint"),
-			bucket:       ctx.String("bucket"),
-			minioOptions: minioOptions,
+		retention: &RetentionOptions{
+			days:    ctx.Int("retention-days"),
+			weekly:  ctx.Int("retention-weekly"),
+			monthly: ctx.Int("retention-monthly"),
 		},
+		storageURLs: ctx.StringSlice("storage-url"),
+		dump: &DumpOptions{
+			format: ctx.String("dump-format"),
+			jobs:   ctx.Int("dump-jobs"),
+		},
+	}
+	if endpoint := ctx.String("endpoint"); endpoint != "" {
+		if ctx.String("bucket") == "" {
+			return fmt.Errorf("--bucket is required when --endpoint is set")
+		}
+		options.minio = &MinioBackuperOptions{
+			endpoint: endpoint,
+			bucket:   ctx.String("bucket"),
+			minioOptions: &minio.Options{
+				Creds:  credentials.NewStaticV4(ctx.String("access-key"), ctx.String("secret-key"), ""),
+				Secure: ctx.Bool("use-ssl"),
+			},
+		}
+	}
+	if options.minio == nil && len(options.storageURLs) == 0 {
+		return fmt.Errorf("at least one storage backend must be configured: set --endpoint for MinIO or --storage-url")
+	}
+	if ctx.String("encrypt-passphrase") != "" || ctx.String("encrypt-pubkey-file") != "" {
+		options.encrypt = &EncryptOptions{
+			passphrase: ctx.String("encrypt-passphrase"),
+			pubKeyFile: ctx.String("encrypt-pubkey-file"),
+		}
+	}
+	if urls := ctx.StringSlice("notify-url"); len(urls) > 0 {
+		notifyOptions, err := newNotifyOptions(urls, ctx.StringSlice("notify-on"), ctx.String("notify-template-subject-file"), ctx.String("notify-template-body-file"))
+		if err != nil {
+			return errors.Wrap(err, "invalid notification configuration")
+		}
+		options.notify = notifyOptions
 	}
 	backuper := NewBackuper(options)
 	if ctx.Bool("do") {
@@ -280,9 +327,10 @@ func Do(ctx *cli.Context) error {
 
 func main() {
 	app := &cli.App{
-		Name:   "postgres-backuper",
-		Usage:  "backup postgres containers to MinIO",
-		Action: Do,
+		Name:     "postgres-backuper",
+		Usage:    "backup postgres containers to MinIO, local disk, SFTP or WebDAV",
+		Action:   Do,
+		Commands: []*cli.Command{decryptCommand},
 		Flags: []cli.Flag{
 			&cli.StringFlag{
 				Name:    "schedule",
@@ -291,28 +339,24 @@ func main() {
 				EnvVars: []string{"PB_SCHEDULE"},
 			},
 			&cli.StringFlag{
-				Name:     "endpoint",
-				Usage:    "MinIO endpoint",
-				Required: true,
-				EnvVars:  []string{"PB_ENDPOINT"},
+				Name:    "endpoint",
+				Usage:   "MinIO endpoint; the default MinIO storage backend is only used if this is set, see --storage-url for MinIO-free setups",
+				EnvVars: []string{"PB_ENDPOINT"},
 			},
 			&cli.StringFlag{
-				Name:     "access-key",
-				Usage:    "MinIO access key",
-				Required: true,
-				EnvVars:  []string{"PB_ACCESS_KEY"},
+				Name:    "access-key",
+				Usage:   "MinIO access key",
+				EnvVars: []string{"PB_ACCESS_KEY"},
 			},
 			&cli.StringFlag{
-				Name:     "secret-key",
-				Usage:    "MinIO secret key",
-				Required: true,
-				EnvVars:  []string{"PB_SECRET_KEY"},
+				Name:    "secret-key",
+				Usage:   "MinIO secret key",
+				EnvVars: []string{"PB_SECRET_KEY"},
 			},
 			&cli.StringFlag{
-				Name:     "bucket",
-				Usage:    "MinIO bucket",
-				Required: true,
-				EnvVars:  []string{"PB_BUCKET"},
+				Name:    "bucket",
+				Usage:   "MinIO bucket; required if --endpoint is set",
+				EnvVars: []string{"PB_BUCKET"},
 			},
 			&cli.BoolFlag{
 				Name:    "use-ssl",
@@ -325,6 +369,71 @@ func main() {
 				Usage: "Execute the backuper now",
 				Value: false,
 			},
+			&cli.IntFlag{
+				Name:    "retention-days",
+				Usage:   "Number of daily backups to keep per app (0 disables daily retention)",
+				Value:   7,
+				EnvVars: []string{"PB_RETENTION_DAYS"},
+			},
+			&cli.IntFlag{
+				Name:    "retention-weekly",
+				Usage:   "Number of weekly backups to keep per app (0 disables weekly retention)",
+				Value:   4,
+				EnvVars: []string{"PB_RETENTION_WEEKLY"},
+			},
+			&cli.IntFlag{
+				Name:    "retention-monthly",
+				Usage:   "Number of monthly backups to keep per app (0 disables monthly retention)",
+				Value:   6,
+				EnvVars: []string{"PB_RETENTION_MONTHLY"},
+			},
+			&cli.StringFlag{
+				Name:    "encrypt-passphrase",
+				Usage:   "Passphrase to symmetrically encrypt backups with before upload",
+				EnvVars: []string{"PB_ENCRYPT_PASSPHRASE"},
+			},
+			&cli.StringFlag{
+				Name:    "encrypt-pubkey-file",
+				Usage:   "Path to an armored OpenPGP public key to encrypt backups with before upload",
+				EnvVars: []string{"PB_ENCRYPT_PUBKEY_FILE"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "notify-url",
+				Usage:   "URL of a notification sink to report backup status to (smtp://, slack://, webhook:// or https://); may be repeated",
+				EnvVars: []string{"PB_NOTIFY_URL"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "notify-on",
+				Usage:   "Events to notify on: success, failure (default: failure)",
+				EnvVars: []string{"PB_NOTIFY_ON"},
+			},
+			&cli.StringFlag{
+				Name:    "notify-template-subject-file",
+				Usage:   "Path to a Go text/template file overriding the notification subject",
+				EnvVars: []string{"PB_NOTIFY_TEMPLATE_SUBJECT_FILE"},
+			},
+			&cli.StringFlag{
+				Name:    "notify-template-body-file",
+				Usage:   "Path to a Go text/template file overriding the notification body",
+				EnvVars: []string{"PB_NOTIFY_TEMPLATE_BODY_FILE"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "storage-url",
+				Usage:   "Additional storage backend to mirror backups to (s3://, file://, sftp:// or webdav://); may be repeated",
+				EnvVars: []string{"PB_STORAGE_URL"},
+			},
+			&cli.StringFlag{
+				Name:    "dump-format",
+				Usage:   "Default pg_dump format: plain, custom or directory (overridden per-container by postgres-backup/format)",
+				Value:   "plain",
+				EnvVars: []string{"PB_DUMP_FORMAT"},
+			},
+			&cli.IntFlag{
+				Name:    "dump-jobs",
+				Usage:   "Default number of parallel jobs for the directory format (overridden per-container by postgres-backup/jobs)",
+				Value:   1,
+				EnvVars: []string{"PB_DUMP_JOBS"},
+			},
 		},
 		HideHelpCommand: true,
 	}
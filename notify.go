@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultSubjectTemplate = "postgres-backuper: {{if .Error}}backup failed for {{.AppName}}{{else}}backup succeeded for {{.AppName}}{{end}}"
+	defaultBodyTemplate    = "Container: {{.Container}}\nDuration: {{.Duration}}\nSize: {{.Size}} bytes\n{{if .Error}}Error: {{.Error}}\n{{end}}Run stats: {{.Stats.Successful}} succeeded, {{.Stats.Failed}} failed"
+)
+
+// NotifyStats summarizes a whole Scan run, passed to the template as .Stats.
+type NotifyStats struct {
+	Successful int
+	Failed     int
+}
+
+// NotifyEvent carries the fields available to notification templates.
+type NotifyEvent struct {
+	AppName   string
+	Container string
+	Error     error
+	Size      int64
+	Duration  time.Duration
+	Stats     NotifyStats
+}
+
+// NotifyOptions configures the notification subsystem.
+type NotifyOptions struct {
+	urls        []string
+	on          map[string]bool
+	subjectTmpl *template.Template
+	bodyTmpl    *template.Template
+}
+
+// newNotifyOptions builds a NotifyOptions from CLI input, loading the
+// subject/body templates from disk if paths are given, falling back to the
+// built-in defaults otherwise.
+func newNotifyOptions(urls, on []string, subjectFile, bodyFile string) (*NotifyOptions, error) {
+	if len(on) == 0 {
+		on = []string{"failure"}
+	}
+	onSet := map[string]bool{}
+	for _, o := range on {
+		onSet[strings.TrimSpace(o)] = true
+	}
+
+	subjectTmpl, err := loadTemplate("subject", subjectFile, defaultSubjectTemplate)
+	if err != nil {
+		return nil, err
+	}
+	bodyTmpl, err := loadTemplate("body", bodyFile, defaultBodyTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NotifyOptions{
+		urls:        urls,
+		on:          onSet,
+		subjectTmpl: subjectTmpl,
+		bodyTmpl:    bodyTmpl,
+	}, nil
+}
+
+func loadTemplate(name, path, fallback string) (*template.Template, error) {
+	text := fallback
+	if path != "" {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to read %s template", name)
+		}
+		text = string(contents)
+	}
+	return template.New(name).Parse(text)
+}
+
+// shouldNotify reports whether an event with the given outcome should be
+// sent, based on the configured --notify-on list ("failure" by default).
+func (o *NotifyOptions) shouldNotify(failed bool) bool {
+	if failed {
+		return o.on["failure"]
+	}
+	return o.on["success"]
+}
+
+// Notifier sends a rendered notification somewhere.
+type Notifier interface {
+	Send(subject, body string) error
+}
+
+// notify renders the configured templates for event and sends them to every
+// configured notifier, logging (but not failing the backup on) send errors.
+func (b *Backuper) notify(event NotifyEvent) {
+	opts := b.options.notify
+	if opts == nil || !opts.shouldNotify(event.Error != nil) {
+		return
+	}
+
+	var subject, body bytes.Buffer
+	if err := opts.subjectTmpl.Execute(&subject, event); err != nil {
+		b.log.Println("Failed to render notification subject:", err)
+		return
+	}
+	if err := opts.bodyTmpl.Execute(&body, event); err != nil {
+		b.log.Println("Failed to render notification body:", err)
+		return
+	}
+
+	for _, rawURL := range opts.urls {
+		notifier, err := newNotifier(rawURL)
+		if err != nil {
+			b.log.Println("Failed to parse notify-url", rawURL, ":", err)
+			continue
+		}
+		if err := notifier.Send(subject.String(), body.String()); err != nil {
+			b.log.Println("Failed to send notification to", rawURL, ":", err)
+		}
+	}
+}
+
+// runStatsError turns a run's failure count into an error so the run-level
+// notification is treated as a failure by shouldNotify when appropriate.
+func runStatsError(stats NotifyStats) error {
+	if stats.Failed == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d/%d backups failed", stats.Failed, stats.Failed+stats.Successful)
+}
+
+// newNotifier dispatches a notify-url to a Notifier based on its scheme, in
+// the spirit of shoutrrr's scheme-based service URLs.
+func newNotifier(rawURL string) (Notifier, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid notify-url")
+	}
+
+	switch parsed.Scheme {
+	case "smtp", "smtps":
+		return &smtpNotifier{url: parsed}, nil
+	case "slack":
+		return &slackNotifier{url: parsed}, nil
+	case "webhook", "http", "https":
+		return &webhookNotifier{url: parsed}, nil
+	default:
+		return nil, fmt.Errorf("unsupported notify-url scheme %q", parsed.Scheme)
+	}
+}
+
+type smtpNotifier struct {
+	url *url.URL
+}
+
+func (n *smtpNotifier) Send(subject, body string) error {
+	password, _ := n.url.User.Password()
+	from := n.url.Query().Get("from")
+	to := n.url.Query().Get("to")
+	if from == "" || to == "" {
+		return errors.New("smtp notify-url requires from and to query parameters")
+	}
+
+	msg := []byte(fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n", from, to, subject, body))
+	auth := smtp.PlainAuth("", n.url.User.Username(), password, n.url.Hostname())
+	return smtp.SendMail(n.url.Host, auth, from, []string{to}, msg)
+}
+
+type slackNotifier struct {
+	url *url.URL
+}
+
+func (n *slackNotifier) Send(subject, body string) error {
+	webhookURL := "https://" + n.url.Host + n.url.Path
+	payload := fmt.Sprintf(`{"text":%q}`, subject+"\n"+body)
+	resp, err := http.Post(webhookURL, "application/json", strings.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("slack webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+type webhookNotifier struct {
+	url *url.URL
+}
+
+func (n *webhookNotifier) Send(subject, body string) error {
+	payload := fmt.Sprintf(`{"subject":%q,"body":%q}`, subject, body)
+	resp, err := http.Post(n.url.String(), "application/json", strings.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RetentionOptions configures the GFS (grandfather-father-son) pruning scheme
+// applied to backups in every configured Storage backend.
+type RetentionOptions struct {
+	days    int
+	weekly  int
+	monthly int
+}
+
+// minPruneCandidates guards against wiping a bucket clean when listing goes
+// wrong (e.g. a misconfigured prefix): pruning is skipped unless at least
+// this many dump objects (globals companions not counted) match the
+// key pattern parseBackupObject expects for the app.
+const minPruneCandidates = 3
+
+type backupObject struct {
+	key       string
+	timestamp time.Time
+}
+
+// PruneOldBackups lists the objects stored for appName in every configured
+// Storage backend and removes the ones that fall outside the configured
+// retention window, keeping the newest backup for each of the last
+// options.days days, options.weekly weeks and options.monthly months, and
+// deleting the rest.
+func (b *Backuper) PruneOldBackups(appName string) {
+	retention := b.options.retention
+	if retention == nil || (retention.days <= 0 && retention.weekly <= 0 && retention.monthly <= 0) {
+		return
+	}
+
+	prefix := appName + "/"
+	for _, storage := range b.storages {
+		b.pruneStorage(storage, prefix, retention)
+	}
+}
+
+func (b *Backuper) pruneStorage(storage Storage, prefix string, retention *RetentionOptions) {
+	objects, err := storage.List(b.ctx, prefix)
+	if err != nil {
+		b.log.Println("Failed to list backups under", prefix, ":", err)
+		return
+	}
+
+	var candidates []backupObject
+	var globals []backupObject
+	for _, object := range objects {
+		ts, isGlobals, ok := parseBackupObject(prefix, object.Key)
+		if !ok {
+			continue
+		}
+		if isGlobals {
+			globals = append(globals, backupObject{key: object.Key, timestamp: ts})
+		} else {
+			candidates = append(candidates, backupObject{key: object.Key, timestamp: ts})
+		}
+	}
+
+	if len(candidates) < minPruneCandidates {
+		return
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].timestamp.After(candidates[j].timestamp)
+	})
+
+	keep := keepSet(candidates, retention)
+
+	var toRemove []string
+	keptTimestamps := map[time.Time]bool{}
+	for _, object := range candidates {
+		if keep[object.key] {
+			keptTimestamps[object.timestamp] = true
+		} else {
+			toRemove = append(toRemove, object.key)
+		}
+	}
+
+	// A globals companion isn't itself a GFS candidate: it's kept exactly
+	// when the dump it was uploaded alongside still is, identified by the
+	// start time they share (see globalsTimestampSuffix).
+	for _, object := range globals {
+		if !keptTimestamps[object.timestamp] {
+			toRemove = append(toRemove, object.key)
+		}
+	}
+
+	if len(toRemove) == 0 {
+		return
+	}
+
+	if err := storage.Delete(b.ctx, toRemove); err != nil {
+		b.log.Println("Failed to prune backups under", prefix, ":", err)
+		return
+	}
+	for _, key := range toRemove {
+		b.log.Println("Pruned old backup", key)
+	}
+}
+
+// keepSet applies the GFS scheme to candidates (sorted newest first) and
+// returns the set of object keys that should be kept.
+func keepSet(candidates []backupObject, retention *RetentionOptions) map[string]bool {
+	keep := map[string]bool{}
+	seenDays := map[string]bool{}
+	seenWeeks := map[string]bool{}
+	seenMonths := map[string]bool{}
+
+	for _, object := range candidates {
+		day := object.timestamp.Format("2006-01-02")
+		year, week := object.timestamp.ISOWeek()
+		weekKey := fmt.Sprintf("%d-W%02d", year, week)
+		month := object.timestamp.Format("2006-01")
+
+		if retention.days > 0 && len(seenDays) < retention.days && !seenDays[day] {
+			seenDays[day] = true
+			keep[object.key] = true
+		}
+		if retention.weekly > 0 && len(seenWeeks) < retention.weekly && !seenWeeks[weekKey] {
+			seenWeeks[weekKey] = true
+			keep[object.key] = true
+		}
+		if retention.monthly > 0 && len(seenMonths) < retention.monthly && !seenMonths[month] {
+			seenMonths[month] = true
+			keep[object.key] = true
+		}
+	}
+
+	return keep
+}
+
+// knownDumpExtensions are the extensions UploadDump writes objects under,
+// longest/most specific first so "globals.sql" is tried before "sql".
+var knownDumpExtensions = []string{"globals.sql", "sql", "dump", "tar"}
+
+// parseBackupObject extracts the timestamp embedded in a backup object key,
+// only matching the "<prefix><timestamp>.<ext>" and
+// "<prefix><timestamp>.<ext>.gpg" patterns written by UploadDump, for one of
+// knownDumpExtensions. isGlobals reports whether the key carries
+// globalsTimestampSuffix, in which case timestamp is the start time of the
+// dump it was uploaded alongside, not an independent point in time.
+func parseBackupObject(prefix, key string) (timestamp time.Time, isGlobals bool, ok bool) {
+	if !strings.HasPrefix(key, prefix) {
+		return time.Time{}, false, false
+	}
+	name := strings.TrimSuffix(strings.TrimPrefix(key, prefix), ".gpg")
+
+	for _, ext := range knownDumpExtensions {
+		if !strings.HasSuffix(name, "."+ext) {
+			continue
+		}
+		stamp := strings.TrimSuffix(name, "."+ext)
+		if isGlobals = strings.HasSuffix(stamp, globalsTimestampSuffix); isGlobals {
+			stamp = strings.TrimSuffix(stamp, globalsTimestampSuffix)
+		}
+		if ts, err := time.Parse(time.RFC3339, stamp); err == nil {
+			return ts, isGlobals, true
+		}
+		return time.Time{}, false, false
+	}
+	return time.Time{}, false, false
+}
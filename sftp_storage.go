@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// sftpStorage writes backups to a directory on a remote host over SFTP.
+type sftpStorage struct {
+	client  *sftp.Client
+	baseDir string
+}
+
+// newSFTPStorage connects to the host in parsed (sftp://user@host/path),
+// authenticating with the password from the URL's user info or, if none is
+// given there, with its "password" query parameter. No other SSH
+// authentication method (agent or key file) is tried.
+func newSFTPStorage(parsed *url.URL) (*sftpStorage, error) {
+	host := parsed.Host
+	if parsed.Port() == "" {
+		host = fmt.Sprintf("%s:22", host)
+	}
+
+	config := &ssh.ClientConfig{
+		User:            parsed.User.Username(),
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	if password, ok := parsed.User.Password(); ok {
+		config.Auth = append(config.Auth, ssh.Password(password))
+	} else if password := parsed.Query().Get("password"); password != "" {
+		config.Auth = append(config.Auth, ssh.Password(password))
+	}
+
+	sshClient, err := ssh.Dial("tcp", host, config)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to connect over SSH")
+	}
+
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, errors.Wrap(err, "unable to start SFTP session")
+	}
+
+	return &sftpStorage{client: client, baseDir: parsed.Path}, nil
+}
+
+func (s *sftpStorage) resolve(key string) (string, error) {
+	return resolveUnderPath(s.baseDir, key)
+}
+
+func (s *sftpStorage) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	remotePath, err := s.resolve(key)
+	if err != nil {
+		return err
+	}
+	if err := s.client.MkdirAll(path.Dir(remotePath)); err != nil {
+		return errors.Wrap(err, "unable to create remote backup directory")
+	}
+	f, err := s.client.Create(remotePath)
+	if err != nil {
+		return errors.Wrap(err, "unable to create remote backup file")
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return errors.Wrap(err, "unable to write remote backup file")
+	}
+	return nil
+}
+
+func (s *sftpStorage) List(ctx context.Context, prefix string) ([]StorageObject, error) {
+	root, err := s.resolve(prefix)
+	if err != nil {
+		return nil, err
+	}
+	var objects []StorageObject
+	walker := s.client.Walk(root)
+	for walker.Step() {
+		if walker.Err() != nil {
+			if os.IsNotExist(walker.Err()) {
+				continue
+			}
+			return nil, walker.Err()
+		}
+		if walker.Stat().IsDir() {
+			continue
+		}
+		rel := relUnderPath(s.baseDir, walker.Path())
+		objects = append(objects, StorageObject{Key: rel, LastModified: walker.Stat().ModTime()})
+	}
+	return objects, nil
+}
+
+func (s *sftpStorage) Delete(ctx context.Context, keys []string) error {
+	var aggregated error
+	for _, key := range keys {
+		remotePath, err := s.resolve(key)
+		if err != nil {
+			aggregated = multierror(aggregated, err)
+			continue
+		}
+		if err := s.client.Remove(remotePath); err != nil {
+			aggregated = multierror(aggregated, err)
+		}
+	}
+	return aggregated
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/pkg/errors"
+)
+
+// StorageObject describes an object held by a Storage backend, as returned
+// by List.
+type StorageObject struct {
+	Key          string
+	LastModified time.Time
+}
+
+// Storage is a backup destination. Backuper fans uploads, listings and
+// deletions out across every configured Storage so backups can land on
+// MinIO/S3, a local directory, SFTP or WebDAV without forking the tool.
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	List(ctx context.Context, prefix string) ([]StorageObject, error)
+	Delete(ctx context.Context, keys []string) error
+}
+
+// newStorage builds a Storage from a storage-url, dispatching on its scheme:
+// s3://access:secret@endpoint/bucket, file:///path, sftp://user@host/path or
+// webdav://host/path.
+func newStorage(rawURL string) (Storage, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid storage-url %q", rawURL)
+	}
+
+	switch parsed.Scheme {
+	case "s3":
+		return newMinioStorageFromURL(parsed)
+	case "file":
+		return newLocalStorage(parsed.Path), nil
+	case "sftp":
+		return newSFTPStorage(parsed)
+	case "webdav", "webdavs":
+		return newWebDAVStorage(parsed)
+	default:
+		return nil, fmt.Errorf("unsupported storage-url scheme %q", parsed.Scheme)
+	}
+}
+
+// minioStorage adapts a MinIO/S3-compatible client to the Storage interface.
+type minioStorage struct {
+	client *minio.Client
+	bucket string
+}
+
+func newMinioStorage(options *MinioBackuperOptions) (*minioStorage, error) {
+	client, err := minio.New(options.endpoint, options.minioOptions)
+	if err != nil {
+		return nil, err
+	}
+	return &minioStorage{client: client, bucket: options.bucket}, nil
+}
+
+// newMinioStorageFromURL builds a minioStorage from a storage-url of the
+// form s3://access:secret@endpoint/bucket?ssl=true.
+func newMinioStorageFromURL(parsed *url.URL) (*minioStorage, error) {
+	if parsed.Path == "" || parsed.Path == "/" {
+		return nil, fmt.Errorf("s3 storage-url %q is missing a bucket path", parsed.Redacted())
+	}
+	secret, _ := parsed.User.Password()
+	useSSL := true
+	if ssl := parsed.Query().Get("ssl"); ssl != "" {
+		parsedSSL, err := strconv.ParseBool(ssl)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid ssl query parameter")
+		}
+		useSSL = parsedSSL
+	}
+	return newMinioStorage(&MinioBackuperOptions{
+		endpoint: parsed.Host,
+		bucket:   strings.TrimPrefix(parsed.Path, "/"),
+		minioOptions: &minio.Options{
+			Creds:  credentials.NewStaticV4(parsed.User.Username(), secret, ""),
+			Secure: useSSL,
+		},
+	})
+}
+
+// resolveUnderDir joins baseDir and key with filepath semantics and rejects
+// the result if it would escape baseDir. key ultimately comes from appName
+// (see getAppName in main.go), which is taken verbatim from a container
+// label or name, so without this check a key like "../../etc/cron.d" could
+// make localStorage touch files outside its configured directory.
+func resolveUnderDir(baseDir, key string) (string, error) {
+	joined := filepath.Join(baseDir, filepath.FromSlash(key))
+	base := filepath.Clean(baseDir)
+	if joined != base && !strings.HasPrefix(joined, base+string(filepath.Separator)) {
+		return "", fmt.Errorf("resolved path for key %q escapes base directory %q", key, baseDir)
+	}
+	return joined, nil
+}
+
+// resolveUnderPath is resolveUnderDir for the forward-slash paths the SFTP
+// and WebDAV backends operate on instead of OS filepaths.
+func resolveUnderPath(baseDir, key string) (string, error) {
+	joined := path.Join(baseDir, key)
+	base := path.Clean(baseDir)
+	if joined != base && !strings.HasPrefix(joined, base+"/") {
+		return "", fmt.Errorf("resolved path for key %q escapes base directory %q", key, baseDir)
+	}
+	return joined, nil
+}
+
+// relUnderPath returns p relative to baseDir, both forward-slash paths.
+// Unlike resolveUnderDir's filepath counterpart, the standard library's
+// "path" package has no Rel; since callers only ever pass a p they already
+// resolved under baseDir (via resolveUnderPath), a plain prefix trim is
+// enough.
+func relUnderPath(baseDir, p string) string {
+	rel := strings.TrimPrefix(path.Clean(p), path.Clean(baseDir))
+	return strings.TrimPrefix(rel, "/")
+}
+
+func (m *minioStorage) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	_, err := m.client.PutObject(ctx, m.bucket, key, r, size, minio.PutObjectOptions{})
+	return err
+}
+
+func (m *minioStorage) List(ctx context.Context, prefix string) ([]StorageObject, error) {
+	var objects []StorageObject
+	for object := range m.client.ListObjects(ctx, m.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		objects = append(objects, StorageObject{Key: object.Key, LastModified: object.LastModified})
+	}
+	return objects, nil
+}
+
+func (m *minioStorage) Delete(ctx context.Context, keys []string) error {
+	objectsCh := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objectsCh)
+		for _, key := range keys {
+			objectsCh <- minio.ObjectInfo{Key: key}
+		}
+	}()
+
+	var aggregated error
+	for result := range m.client.RemoveObjects(ctx, m.bucket, objectsCh, minio.RemoveObjectsOptions{}) {
+		if result.Err != nil {
+			aggregated = multierror(aggregated, result.Err)
+		}
+	}
+	return aggregated
+}
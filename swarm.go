@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	containertypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/pkg/errors"
+)
+
+// stopDuringBackupLabel names containers and services that must be paused
+// for a consistent snapshot: everything sharing the same label value, apart
+// from the container being backed up, is stopped (or scaled to zero) before
+// the dump and restored afterwards.
+const stopDuringBackupLabel = "postgres-backup/stop-during-backup"
+
+// scanSwarmServices lists Docker Swarm services labeled postgres-backup=true
+// and resolves each to one of its currently running task containers, so it
+// can be backed up the same way a standalone container would.
+func (b *Backuper) scanSwarmServices() []types.Container {
+	services, err := b.cli.ServiceList(b.ctx, types.ServiceListOptions{
+		Filters: filters.NewArgs(filters.KeyValuePair{Key: "label", Value: "postgres-backup=true"}),
+	})
+	if err != nil {
+		b.log.Println("Failed to list swarm services:", err)
+		return nil
+	}
+
+	var containers []types.Container
+	for _, service := range services {
+		container, err := b.resolveServiceContainer(service)
+		if err != nil {
+			b.log.Println("Failed to resolve a container for service", service.Spec.Name, ":", err)
+			continue
+		}
+		containers = append(containers, container)
+	}
+	return containers
+}
+
+// resolveServiceContainer finds the container backing one of service's
+// running tasks, merging in the service's labels so postgres-backup/* labels
+// declared on the service are honored the same as on a standalone container.
+func (b *Backuper) resolveServiceContainer(service swarm.Service) (types.Container, error) {
+	tasks, err := b.cli.TaskList(b.ctx, types.TaskListOptions{
+		Filters: filters.NewArgs(filters.KeyValuePair{Key: "service", Value: service.ID}),
+	})
+	if err != nil {
+		return types.Container{}, errors.Wrap(err, "unable to list tasks")
+	}
+
+	for _, task := range tasks {
+		if task.Status.State != swarm.TaskStateRunning || task.Status.ContainerStatus == nil {
+			continue
+		}
+		containers, err := b.cli.ContainerList(b.ctx, types.ContainerListOptions{
+			Filters: filters.NewArgs(filters.KeyValuePair{Key: "id", Value: task.Status.ContainerStatus.ContainerID}),
+		})
+		if err != nil || len(containers) == 0 {
+			continue
+		}
+		container := containers[0]
+		if container.Labels == nil {
+			container.Labels = map[string]string{}
+		}
+		for key, value := range service.Spec.Labels {
+			if _, ok := container.Labels[key]; !ok {
+				container.Labels[key] = value
+			}
+		}
+		return container, nil
+	}
+	return types.Container{}, fmt.Errorf("no running task found for service %s", service.Spec.Name)
+}
+
+// quiesceGroup stops every other container and scales down every swarm
+// service sharing container's postgres-backup/stop-during-backup group label, ahead
+// of a backup. The returned restore func brings them back and should be
+// deferred so it always runs, even if the backup itself fails.
+func (b *Backuper) quiesceGroup(container types.Container) (restore func(), err error) {
+	group, ok := container.Labels[stopDuringBackupLabel]
+	if !ok {
+		return func() {}, nil
+	}
+
+	groupFilter := filters.NewArgs(filters.KeyValuePair{Key: "label", Value: fmt.Sprintf("%s=%s", stopDuringBackupLabel, group)})
+
+	containers, err := b.cli.ContainerList(b.ctx, types.ContainerListOptions{Filters: groupFilter})
+	if err != nil {
+		return func() {}, errors.Wrap(err, "unable to list containers for group")
+	}
+	services, err := b.cli.ServiceList(b.ctx, types.ServiceListOptions{Filters: groupFilter})
+	if err != nil {
+		return func() {}, errors.Wrap(err, "unable to list services for group")
+	}
+
+	replicas := map[string]uint64{}
+	for _, service := range services {
+		if service.Spec.Mode.Replicated != nil && service.Spec.Mode.Replicated.Replicas != nil {
+			replicas[service.ID] = *service.Spec.Mode.Replicated.Replicas
+		}
+	}
+
+	for _, c := range containers {
+		if c.ID == container.ID {
+			continue
+		}
+		if err := b.cli.ContainerStop(b.ctx, c.ID, containertypes.StopOptions{}); err != nil {
+			b.log.Println("Failed to stop", c.ID[:12], "for group", group, ":", err)
+		}
+	}
+	for _, service := range services {
+		if err := b.scaleService(service, 0); err != nil {
+			b.log.Println("Failed to scale down service", service.Spec.Name, ":", err)
+		}
+	}
+
+	return func() {
+		for _, c := range containers {
+			if c.ID == container.ID {
+				continue
+			}
+			if err := b.cli.ContainerStart(b.ctx, c.ID, types.ContainerStartOptions{}); err != nil {
+				b.log.Println("Failed to restart", c.ID[:12], "for group", group, ":", err)
+			}
+		}
+		for _, service := range services {
+			if want, ok := replicas[service.ID]; ok {
+				if err := b.scaleService(service, want); err != nil {
+					b.log.Println("Failed to scale service", service.Spec.Name, "back up:", err)
+				}
+			}
+		}
+	}, nil
+}
+
+func (b *Backuper) scaleService(service swarm.Service, replicas uint64) error {
+	if service.Spec.Mode.Replicated == nil {
+		return nil
+	}
+	spec := service.Spec
+	spec.Mode.Replicated.Replicas = &replicas
+	_, err := b.cli.ServiceUpdate(b.ctx, service.ID, service.Version, spec, types.ServiceUpdateOptions{})
+	return err
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"path"
+
+	"github.com/pkg/errors"
+	"github.com/studio-b12/gowebdav"
+)
+
+// webdavStorage writes backups to a WebDAV share.
+type webdavStorage struct {
+	client  *gowebdav.Client
+	baseDir string
+}
+
+// newWebDAVStorage builds a webdavStorage from a webdav(s)://[user:pass@]host/path URL.
+func newWebDAVStorage(parsed *url.URL) (*webdavStorage, error) {
+	scheme := "http"
+	if parsed.Scheme == "webdavs" {
+		scheme = "https"
+	}
+	password, _ := parsed.User.Password()
+	root := scheme + "://" + parsed.Host
+	client := gowebdav.NewClient(root, parsed.User.Username(), password)
+
+	if err := client.Connect(); err != nil {
+		return nil, errors.Wrap(err, "unable to connect to WebDAV server")
+	}
+
+	return &webdavStorage{client: client, baseDir: parsed.Path}, nil
+}
+
+func (w *webdavStorage) resolve(key string) (string, error) {
+	return resolveUnderPath(w.baseDir, key)
+}
+
+func (w *webdavStorage) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	remotePath, err := w.resolve(key)
+	if err != nil {
+		return err
+	}
+	if err := w.client.MkdirAll(path.Dir(remotePath), 0o755); err != nil {
+		return errors.Wrap(err, "unable to create remote backup directory")
+	}
+	if err := w.client.WriteStream(remotePath, r, 0o644); err != nil {
+		return errors.Wrap(err, "unable to write remote backup file")
+	}
+	return nil
+}
+
+func (w *webdavStorage) List(ctx context.Context, prefix string) ([]StorageObject, error) {
+	root, err := w.resolve(prefix)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := w.client.ReadDir(root)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list WebDAV directory")
+	}
+	var objects []StorageObject
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		rel := relUnderPath(w.baseDir, path.Join(root, info.Name()))
+		objects = append(objects, StorageObject{Key: rel, LastModified: info.ModTime()})
+	}
+	return objects, nil
+}
+
+func (w *webdavStorage) Delete(ctx context.Context, keys []string) error {
+	var aggregated error
+	for _, key := range keys {
+		remotePath, err := w.resolve(key)
+		if err != nil {
+			aggregated = multierror(aggregated, err)
+			continue
+		}
+		if err := w.client.Remove(remotePath); err != nil {
+			aggregated = multierror(aggregated, err)
+		}
+	}
+	return aggregated
+}